@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Martinvks/httptestrunner/http2"
+	"github.com/Martinvks/httptestrunner/http3"
+	"github.com/Martinvks/httptestrunner/types"
+	"github.com/spf13/cobra"
+)
+
+var multiStreamFile string
+
+func init() {
+	multiStreamCmd.Flags().StringVar(
+		&multiStreamFile,
+		"script",
+		"",
+		"path to a JSON multi-stream script: an ordered list of requests (and, for h2, raw headers/resets) to drive over a single persistent connection",
+	)
+	_ = multiStreamCmd.MarkFlagRequired("script")
+
+	rootCmd.AddCommand(multiStreamCmd)
+}
+
+var multiStreamCmd = &cobra.Command{
+	Use:   "multi-stream",
+	Short: "Send a scripted sequence of requests over a single persistent connection, each with its own timing",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch commonArgs.proto {
+		case types.H2:
+			script, err := http2.LoadMultiStreamScript(multiStreamFile)
+			if err != nil {
+				return err
+			}
+			traceWriter, err := openTraceFile(commonArgs.traceFile)
+			if err != nil {
+				return err
+			}
+			var trace *http2.Tracer
+			if traceWriter != nil {
+				trace = http2.NewTracer(traceWriter)
+			}
+			conn, err := http2.DialConnection(commonArgs.target, commonArgs.timeout, commonArgs.keyLogFile, commonArgs.transport, trace)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = conn.Close() }()
+
+			responses, err := http2.RunMultiStreamScript(conn, script)
+			for i, resp := range responses {
+				if resp == nil {
+					continue
+				}
+				fmt.Printf("--- stream %d ---\n", script.Actions[i].StreamID)
+				printResponse(resp.Headers, resp.Body)
+			}
+			return err
+
+		case types.H3:
+			script, err := http3.LoadMultiStreamScript(multiStreamFile)
+			if err != nil {
+				return err
+			}
+			var keyLogWriter io.Writer
+			if commonArgs.keyLogFile != "" {
+				f, err := os.OpenFile(commonArgs.keyLogFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+				if err != nil {
+					return err
+				}
+				keyLogWriter = f
+			}
+			traceWriter, err := openTraceFile(commonArgs.traceFile)
+			if err != nil {
+				return err
+			}
+			var trace *http3.Tracer
+			if traceWriter != nil {
+				trace = http3.NewTracer(traceWriter)
+			}
+			conn, err := http3.DialConnection(commonArgs.target, commonArgs.timeout, keyLogWriter, commonArgs.h3Settings, commonArgs.qpackInserts, trace)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = conn.Close() }()
+
+			responses, err := http3.RunMultiStreamScript(conn, script)
+			for i, resp := range responses {
+				if resp == nil {
+					continue
+				}
+				fmt.Printf("--- request %d ---\n", i)
+				printResponse(toUtilsHeaders(types.Headers(resp.Headers)), resp.Body)
+			}
+			return err
+
+		default:
+			return fmt.Errorf("unknown protocol")
+		}
+	},
+}
@@ -2,28 +2,40 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/Martinvks/httptestrunner/http2"
+	"github.com/Martinvks/httptestrunner/http3"
 	"github.com/Martinvks/httptestrunner/types"
+	"github.com/Martinvks/httptestrunner/utils"
 	"github.com/spf13/cobra"
 )
 
 type commonArguments struct {
-	addIdHeader   bool
-	commonHeaders types.Headers
-	keyLogFile    string
-	proto         int
-	timeout       time.Duration
-	target        *url.URL
+	addIdHeader       bool
+	commonHeaders     types.Headers
+	continuationSplit []int
+	framesFile        string
+	traceFile         string
+	keyLogFile        string
+	proto             int
+	transport         http2.Transport
+	timeout           time.Duration
+	target            *url.URL
+	h3Settings        []http3.SettingSpec
+	qpackInserts      []http3.DynamicTableEntry
 }
 
 var (
-	headers    []string
-	proto      string
-	commonArgs commonArguments
+	headers      []string
+	proto        string
+	h3Settings   []string
+	qpackInserts []string
+	commonArgs   commonArguments
 )
 
 func init() {
@@ -63,7 +75,42 @@ func init() {
 		"protocol",
 		"p",
 		"h2",
-		"specifies which protocol to use. Must be one of \"h2\" or \"h3\"",
+		"specifies which protocol to use. Must be one of \"h2\" (TLS), \"h2c\" (cleartext, prior knowledge), \"h2c-upgrade\" (cleartext, HTTP/1.1 Upgrade) or \"h3\"",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&commonArgs.framesFile,
+		"frames",
+		"",
+		"path to a JSON frame script that replaces the implicit request assembly with an explicit, ordered list of frames",
+	)
+
+	rootCmd.PersistentFlags().IntSliceVar(
+		&commonArgs.continuationSplit,
+		"continuation-split",
+		[]int{},
+		"h2 only: byte offsets into the encoded header block at which to split it into a HEADERS frame followed by one CONTINUATION frame per offset. ignored when --frames is set",
+	)
+
+	rootCmd.PersistentFlags().StringArrayVar(
+		&h3Settings,
+		"h3-setting",
+		[]string{},
+		"h3 only: a SETTINGS parameter sent on the local control stream, syntax \"id=val\" (both decimal). repeatable. ignored when the --frames script has non-empty control_frames",
+	)
+
+	rootCmd.PersistentFlags().StringArrayVar(
+		&qpackInserts,
+		"qpack-insert",
+		[]string{},
+		"h3 only: pre-populate an entry into the peer's QPACK dynamic table via the encoder stream, syntax \"name=value\". repeatable",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&commonArgs.traceFile,
+		"trace",
+		"",
+		"path to write a JSON-lines trace of every frame observed on the connection, timestamped relative to the start of the trace",
 	)
 
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
@@ -90,12 +137,35 @@ var rootCmd = &cobra.Command{
 		switch proto {
 		case "h2":
 			commonArgs.proto = types.H2
+			commonArgs.transport = http2.TransportTLS
+		case "h2c":
+			commonArgs.proto = types.H2
+			commonArgs.transport = http2.TransportH2C
+		case "h2c-upgrade":
+			commonArgs.proto = types.H2
+			commonArgs.transport = http2.TransportH2CUpgrade
 		case "h3":
 			commonArgs.proto = types.H3
 		default:
 			return fmt.Errorf("unknown protocol '%s'", proto)
 		}
 
+		for _, s := range h3Settings {
+			var id, val uint64
+			if _, err := fmt.Sscanf(s, "%d=%d", &id, &val); err != nil {
+				return fmt.Errorf("invalid h3-setting '%s', expected syntax: 'id=val'", s)
+			}
+			commonArgs.h3Settings = append(commonArgs.h3Settings, http3.SettingSpec{ID: id, Val: val})
+		}
+
+		for _, s := range qpackInserts {
+			parts := strings.SplitN(s, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid qpack-insert '%s', expected syntax: 'name=value'", s)
+			}
+			commonArgs.qpackInserts = append(commonArgs.qpackInserts, http3.DynamicTableEntry{Name: parts[0], Value: parts[1]})
+		}
+
 		target, err := url.Parse(args[0])
 		if err != nil {
 			return err
@@ -104,6 +174,114 @@ var rootCmd = &cobra.Command{
 
 		return nil
 	},
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch commonArgs.proto {
+		case types.H2:
+			var frameScript *http2.FrameScript
+			if commonArgs.framesFile != "" {
+				loaded, err := http2.LoadFrameScript(commonArgs.framesFile)
+				if err != nil {
+					return err
+				}
+				frameScript = loaded
+			}
+			traceWriter, err := openTraceFile(commonArgs.traceFile)
+			if err != nil {
+				return err
+			}
+			var trace *http2.Tracer
+			if traceWriter != nil {
+				trace = http2.NewTracer(traceWriter)
+			}
+			response, err := http2.SendHTTP2Request(
+				commonArgs.target,
+				commonArgs.timeout,
+				commonArgs.keyLogFile,
+				commonArgs.transport,
+				utils.HTTPMessage{Headers: toUtilsHeaders(commonArgs.commonHeaders)},
+				frameScript,
+				commonArgs.continuationSplit,
+				trace,
+			)
+			if err != nil {
+				return err
+			}
+			printResponse(response.Headers, response.Body)
+			return nil
+
+		case types.H3:
+			var frameScript *http3.FrameScript
+			if commonArgs.framesFile != "" {
+				loaded, err := http3.LoadFrameScript(commonArgs.framesFile)
+				if err != nil {
+					return err
+				}
+				frameScript = loaded
+			}
+			var keyLogWriter io.Writer
+			if commonArgs.keyLogFile != "" {
+				f, err := os.OpenFile(commonArgs.keyLogFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+				if err != nil {
+					return err
+				}
+				keyLogWriter = f
+			}
+			traceWriter, err := openTraceFile(commonArgs.traceFile)
+			if err != nil {
+				return err
+			}
+			var trace *http3.Tracer
+			if traceWriter != nil {
+				trace = http3.NewTracer(traceWriter)
+			}
+			response, err := http3.SendHTTP3Request(
+				commonArgs.target,
+				commonArgs.timeout,
+				keyLogWriter,
+				&types.HttpRequest{Headers: commonArgs.commonHeaders},
+				frameScript,
+				commonArgs.h3Settings,
+				commonArgs.qpackInserts,
+				trace,
+			)
+			if err != nil {
+				return err
+			}
+			printResponse(toUtilsHeaders(response.Headers), response.Body)
+			return nil
+
+		default:
+			return fmt.Errorf("unknown protocol")
+		}
+	},
+}
+
+// openTraceFile opens path for the "--trace" flag, truncating any existing
+// file. It returns a nil writer, rather than an error, when path is empty,
+// so callers can use the result directly to decide whether to construct a
+// Tracer.
+func openTraceFile(path string) (io.Writer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+func toUtilsHeaders(headers types.Headers) []utils.Header {
+	result := make([]utils.Header, len(headers))
+	for i, h := range headers {
+		result[i] = utils.Header{Name: h.Name, Value: h.Value}
+	}
+	return result
+}
+
+func printResponse(headers []utils.Header, body []byte) {
+	for _, h := range headers {
+		fmt.Printf("%s: %s\n", h.Name, h.Value)
+	}
+	fmt.Println()
+	os.Stdout.Write(body)
 }
 
 func Execute() {
@@ -0,0 +1,364 @@
+package http2
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/Martinvks/httptestrunner/utils"
+)
+
+// StreamSpec selects the stream a Connection.SendRequest call uses and how
+// it is paced relative to other calls on the same Connection. ID need not
+// be odd, increasing, or unused: reused, even, or out-of-order IDs are all
+// accepted, to reproduce cross-stream smuggling and cancellation races.
+type StreamSpec struct {
+	ID uint32
+	// Delay is how long to wait, before acquiring the connection's write
+	// lock, before writing this request's frames. Waiting happens before
+	// the lock is taken so concurrent calls with different Delay values
+	// race for the lock (and so the wire) in Delay order, letting a
+	// caller interleave requests across goroutines with a controlled
+	// ordering.
+	Delay time.Duration
+}
+
+// Connection is a single connection shared across multiple logical HTTP/2
+// requests. Unlike SendHTTP2Request, it does not close the connection
+// after one request: callers drive it with repeated SendRequest calls,
+// each free to choose its own stream ID.
+type Connection struct {
+	conn    net.Conn
+	framer  *http2.Framer
+	timeout time.Duration
+	trace   *Tracer
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint32]*pendingStream
+}
+
+type pendingStream struct {
+	msg         utils.HTTPMessage
+	decoder     *hpack.Decoder
+	hasBody     bool
+	headersDone bool
+	done        chan error
+	// finished guards against finish being called twice for the same
+	// pendingStream, which would otherwise block forever on the second
+	// send to done (it's only ever read once).
+	finished bool
+}
+
+// DialConnection opens the connection and sends the client preface,
+// initial SETTINGS and initial WINDOW_UPDATE, the same as SendHTTP2Request
+// does for a one-shot request. transport selects how the connection is
+// established before HTTP/2 framing begins, the same as for
+// SendHTTP2Request, except TransportH2CUpgrade: a persistent Connection
+// has no single initial request to stand in for stream 1, so it is
+// rejected with an error. A background goroutine then demultiplexes
+// incoming frames by stream ID for SendRequest. If trace is non-nil, every
+// frame read from the connection is recorded to it (see Tracer).
+func DialConnection(target *url.URL, timeout time.Duration, keyLogFile string, transport Transport, trace *Tracer) (*Connection, error) {
+	ip, err := utils.LookUp(target.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	port := target.Port()
+	if port == "" {
+		if transport == TransportTLS {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+
+	tcpConn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), port), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var c net.Conn = tcpConn
+	switch transport {
+	case TransportTLS:
+		var keyLogWriter io.Writer
+		if keyLogFile != "" {
+			keyLogWriter, err = os.OpenFile(keyLogFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				_ = tcpConn.Close()
+				return nil, err
+			}
+		}
+		c = tls.Client(tcpConn, &tls.Config{
+			NextProtos:         []string{"h2"},
+			ServerName:         target.Hostname(),
+			InsecureSkipVerify: true,
+			KeyLogWriter:       keyLogWriter,
+		})
+
+	case TransportH2C:
+		// prior knowledge: nothing to negotiate, the preface is sent as-is.
+
+	default:
+		_ = tcpConn.Close()
+		return nil, fmt.Errorf("transport %v is not supported for a persistent multi-stream connection", transport)
+	}
+
+	if _, err := c.Write([]byte(http2.ClientPreface)); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	framer := http2.NewFramer(c, c)
+
+	if err := framer.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: (1 << 30) - 1}); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	if err := framer.WriteWindowUpdate(0, (1<<30)-(1<<16)-1); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	conn := &Connection{
+		conn:    c,
+		framer:  framer,
+		timeout: timeout,
+		trace:   trace,
+		streams: make(map[uint32]*pendingStream),
+	}
+	go conn.readLoop()
+	return conn, nil
+}
+
+// Close closes the underlying connection.
+func (c *Connection) Close() error {
+	return c.conn.Close()
+}
+
+// SendRequest writes headers and body on stream.ID, encoding headers with
+// each field's own HPACK representation (see HeaderField), and waits for
+// the response. If continuationSplit is non-empty, the encoded header
+// block is fragmented into a HEADERS frame followed by one CONTINUATION
+// frame per offset (see splitHeaderBlock), the same as the one-shot
+// --continuation-split path; an empty continuationSplit sends a single
+// HEADERS frame regardless of block size. Multiple goroutines may call
+// SendRequest concurrently on the same Connection: stream.Delay is waited
+// out before the write lock is even acquired, so concurrent calls with
+// different delays race for the lock (and so the wire) in delay order
+// rather than serialized behind whichever call happens to acquire the
+// lock first. It is an error for two SendRequest calls to have an
+// outstanding response on the same stream.ID at once, since only one
+// pendingStream can be demultiplexed per ID.
+func (c *Connection) SendRequest(stream StreamSpec, headers []HeaderField, body []byte, continuationSplit []int) (utils.HTTPMessage, error) {
+	st := &pendingStream{done: make(chan error, 1)}
+	st.decoder = hpack.NewDecoder(^uint32(0), func(f hpack.HeaderField) {
+		st.msg.Headers = append(st.msg.Headers, utils.Header{Name: f.Name, Value: f.Value})
+	})
+
+	c.mu.Lock()
+	if _, pending := c.streams[stream.ID]; pending {
+		c.mu.Unlock()
+		return utils.HTTPMessage{}, fmt.Errorf("stream %d already has a request awaiting a response", stream.ID)
+	}
+	c.streams[stream.ID] = st
+	c.mu.Unlock()
+
+	if stream.Delay > 0 {
+		time.Sleep(stream.Delay)
+	}
+	c.writeMu.Lock()
+	err := writeHeadersAndData(c.framer, stream.ID, headers, body, continuationSplit)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.finish(stream.ID, st, nil)
+		return utils.HTTPMessage{}, err
+	}
+
+	select {
+	case err := <-st.done:
+		if err != nil {
+			return utils.HTTPMessage{}, err
+		}
+		return st.msg, nil
+	case <-time.After(c.timeout):
+		timeoutErr := fmt.Errorf("timeout waiting for response on stream %d", stream.ID)
+		c.finish(stream.ID, st, timeoutErr)
+		return utils.HTTPMessage{}, timeoutErr
+	}
+}
+
+// SendRawHeaders writes a HEADERS frame on stream.ID using headerBlock
+// verbatim as the block fragment, without waiting for a response. It is
+// meant for reproducing cross-stream smuggling races where two streams
+// must share byte-identical (and possibly incomplete) header block
+// fragments, which SendRequest's independent per-call HPACK encoding
+// cannot guarantee.
+func (c *Connection) SendRawHeaders(stream StreamSpec, headerBlock []byte, endStream bool) error {
+	if stream.Delay > 0 {
+		time.Sleep(stream.Delay)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      stream.ID,
+		BlockFragment: headerBlock,
+		EndStream:     endStream,
+		EndHeaders:    true,
+	})
+}
+
+// ResetStream writes a RST_STREAM frame on stream.ID without waiting for a
+// response, for example to race a HEADERS frame on a reused stream ID.
+func (c *Connection) ResetStream(stream StreamSpec, errCode http2.ErrCode) error {
+	if stream.Delay > 0 {
+		time.Sleep(stream.Delay)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.framer.WriteRSTStream(stream.ID, errCode)
+}
+
+// writeHeadersAndData writes the HEADERS (+ CONTINUATION, if
+// continuationSplit is non-empty) and DATA frames for headers/body on
+// streamID, encoding each header field with its own HPACK representation
+// (see HeaderField). It does not write the preface or any connection-level
+// frames.
+func writeHeadersAndData(framer *http2.Framer, streamID uint32, headers []HeaderField, body []byte, continuationSplit []int) error {
+	var hpackBuf []byte
+	for i := range headers {
+		hpackBuf = hpackAppendHeader(hpackBuf, &headers[i])
+	}
+
+	fragments := splitHeaderBlock(hpackBuf, continuationSplit)
+	if err := framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: fragments[0],
+		EndStream:     len(body) == 0,
+		EndHeaders:    len(fragments) == 1,
+	}); err != nil {
+		return err
+	}
+	for i := 1; i < len(fragments); i++ {
+		if err := framer.WriteContinuation(streamID, i == len(fragments)-1, fragments[i]); err != nil {
+			return err
+		}
+	}
+
+	start := 0
+	for start < len(body) {
+		end := start + 65536
+		if end > len(body) {
+			end = len(body)
+		}
+		if err := framer.WriteData(streamID, end == len(body), body[start:end]); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// readLoop is the Connection's single reader: http2.Framer is not safe for
+// concurrent ReadFrame calls, so exactly one goroutine ever calls it, and
+// SendRequest callers block on their stream's done channel instead.
+func (c *Connection) readLoop() {
+	for {
+		f, err := c.framer.ReadFrame()
+		if err != nil {
+			c.failAll(err)
+			return
+		}
+
+		switch f := f.(type) {
+		case *http2.HeadersFrame:
+			c.trace.HeaderBlock(f, f.HeaderBlockFragment())
+		case *http2.ContinuationFrame:
+			c.trace.HeaderBlock(f, f.HeaderBlockFragment())
+		default:
+			c.trace.Frame(f)
+		}
+
+		if ga, ok := f.(*http2.GoAwayFrame); ok {
+			c.failAll(fmt.Errorf("received GOAWAY frame: error code %v", ga.ErrCode))
+			return
+		}
+
+		streamID := f.Header().StreamID
+		c.mu.Lock()
+		st, tracked := c.streams[streamID]
+		c.mu.Unlock()
+		if !tracked {
+			continue
+		}
+
+		switch f := f.(type) {
+		case *http2.HeadersFrame:
+			if _, err := st.decoder.Write(f.HeaderBlockFragment()); err != nil {
+				c.finish(streamID, st, err)
+				continue
+			}
+			st.headersDone = f.HeadersEnded()
+			st.hasBody = !f.StreamEnded()
+			if st.headersDone && !st.hasBody {
+				c.finish(streamID, st, nil)
+			}
+
+		case *http2.ContinuationFrame:
+			if _, err := st.decoder.Write(f.HeaderBlockFragment()); err != nil {
+				c.finish(streamID, st, err)
+				continue
+			}
+			st.headersDone = f.HeadersEnded()
+			if st.headersDone && !st.hasBody {
+				c.finish(streamID, st, nil)
+			}
+
+		case *http2.DataFrame:
+			st.msg.Body = append(st.msg.Body, f.Data()...)
+			if f.StreamEnded() {
+				c.finish(streamID, st, nil)
+			}
+
+		case *http2.RSTStreamFrame:
+			c.finish(streamID, st, fmt.Errorf("received RST_STREAM frame: error code %v", f.ErrCode))
+		}
+	}
+}
+
+func (c *Connection) finish(streamID uint32, st *pendingStream, err error) {
+	c.mu.Lock()
+	if st.finished {
+		c.mu.Unlock()
+		return
+	}
+	st.finished = true
+	if current, ok := c.streams[streamID]; ok && current == st {
+		delete(c.streams, streamID)
+	}
+	c.mu.Unlock()
+	st.done <- err
+}
+
+func (c *Connection) failAll(err error) {
+	c.mu.Lock()
+	streams := c.streams
+	c.streams = make(map[uint32]*pendingStream)
+	for _, st := range streams {
+		st.finished = true
+	}
+	c.mu.Unlock()
+	for _, st := range streams {
+		st.done <- err
+	}
+}
@@ -0,0 +1,226 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+// RawBytes is a byte slice that can be specified in a frame script either as
+// a plain JSON string (interpreted as its literal bytes) or, when prefixed
+// with "hex:", as a hex-encoded string. This lets a script describe payloads
+// that are not valid UTF-8, such as raw GOAWAY debug data.
+type RawBytes []byte
+
+func (r *RawBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if rest, ok := strings.CutPrefix(s, "hex:"); ok {
+		b, err := hex.DecodeString(rest)
+		if err != nil {
+			return fmt.Errorf("invalid hex payload: %w", err)
+		}
+		*r = b
+		return nil
+	}
+	*r = []byte(s)
+	return nil
+}
+
+// FrameScript is the top level shape of a `--frames` file: an ordered list
+// of frames to send verbatim in place of the implicit request assembled
+// from utils.HTTPMessage.
+type FrameScript struct {
+	Frames []FrameSpec `json:"frames"`
+}
+
+// FrameSpec describes a single frame. Only the fields relevant to Type are
+// read; the rest are ignored.
+type FrameSpec struct {
+	Type     string `json:"type"`
+	StreamID uint32 `json:"stream_id"`
+
+	// settings
+	Settings []SettingSpec `json:"settings,omitempty"`
+
+	// priority, and the optional priority block on a headers frame
+	StreamDep uint32 `json:"stream_dep,omitempty"`
+	Exclusive bool   `json:"exclusive,omitempty"`
+	Weight    uint8  `json:"weight,omitempty"`
+	HasPrio   bool   `json:"priority,omitempty"`
+
+	// ping
+	PingData RawBytes `json:"ping_data,omitempty"`
+	Ack      bool     `json:"ack,omitempty"`
+
+	// window_update
+	Increment uint32 `json:"increment,omitempty"`
+
+	// headers / continuation
+	Headers    []HeaderField `json:"headers,omitempty"`
+	PadLength  uint8         `json:"pad_length,omitempty"`
+	EndHeaders *bool         `json:"end_headers,omitempty"`
+
+	// headers / data. EndStream defaults to false (the stream is left
+	// open) on both frame types unless explicitly set to true, so a script
+	// listing a "headers" frame followed by a "data" frame carrying a body
+	// doesn't need to repeat it on every frame to avoid prematurely
+	// half-closing the stream.
+	EndStream         *bool `json:"end_stream,omitempty"`
+	ContinuationSplit []int `json:"continuation_split,omitempty"`
+
+	// data
+	Body RawBytes `json:"body,omitempty"`
+
+	// rst_stream / goaway
+	ErrorCode    uint32   `json:"error_code,omitempty"`
+	LastStreamID uint32   `json:"last_stream_id,omitempty"`
+	DebugData    RawBytes `json:"debug_data,omitempty"`
+
+	// unknown/raw frame types
+	RawType uint8    `json:"raw_type,omitempty"`
+	Flags   uint8    `json:"flags,omitempty"`
+	Payload RawBytes `json:"payload,omitempty"`
+}
+
+// SettingSpec is a single SETTINGS parameter. ID is not restricted to the
+// known IDs in golang.org/x/net/http2, so GREASE/unknown settings can be
+// scripted.
+type SettingSpec struct {
+	ID  uint16 `json:"id"`
+	Val uint32 `json:"val"`
+}
+
+// LoadFrameScript reads and parses a frame script file, as passed to
+// `--frames`.
+func LoadFrameScript(path string) (*FrameScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script FrameScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("parsing frame script: %w", err)
+	}
+	return &script, nil
+}
+
+// prepareHTTP2RequestFromScript builds the client preface plus the frames
+// described by script, in order, bypassing the implicit HEADERS/DATA
+// assembly used by prepareHTTP2Request.
+func prepareHTTP2RequestFromScript(script *FrameScript) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte(http2.ClientPreface))
+	framer := http2.NewFramer(buf, nil)
+	for i := range script.Frames {
+		if err := writeScriptedFrame(framer, buf, &script.Frames[i]); err != nil {
+			return nil, fmt.Errorf("frame %d (%s): %w", i, script.Frames[i].Type, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func writeScriptedFrame(framer *http2.Framer, buf *bytes.Buffer, f *FrameSpec) error {
+	switch f.Type {
+	case "settings":
+		settings := make([]http2.Setting, len(f.Settings))
+		for i, s := range f.Settings {
+			settings[i] = http2.Setting{ID: http2.SettingID(s.ID), Val: s.Val}
+		}
+		return framer.WriteSettings(settings...)
+
+	case "settings_ack":
+		return framer.WriteSettingsAck()
+
+	case "priority":
+		return framer.WritePriority(f.StreamID, http2.PriorityParam{
+			StreamDep: f.StreamDep,
+			Exclusive: f.Exclusive,
+			Weight:    f.Weight,
+		})
+
+	case "ping":
+		var data [8]byte
+		copy(data[:], f.PingData)
+		return framer.WritePing(f.Ack, data)
+
+	case "window_update":
+		return framer.WriteWindowUpdate(f.StreamID, f.Increment)
+
+	case "headers":
+		var hpackBuf []byte
+		for i := range f.Headers {
+			hpackBuf = hpackAppendHeader(hpackBuf, &f.Headers[i])
+		}
+		fragments := splitHeaderBlock(hpackBuf, f.ContinuationSplit)
+		param := http2.HeadersFrameParam{
+			StreamID:      f.StreamID,
+			BlockFragment: fragments[0],
+			EndStream:     f.EndStream != nil && *f.EndStream,
+			EndHeaders:    len(fragments) == 1 && (f.EndHeaders == nil || *f.EndHeaders),
+			PadLength:     f.PadLength,
+		}
+		if f.HasPrio {
+			param.Priority = http2.PriorityParam{
+				StreamDep: f.StreamDep,
+				Exclusive: f.Exclusive,
+				Weight:    f.Weight,
+			}
+		}
+		if err := framer.WriteHeaders(param); err != nil {
+			return err
+		}
+		for i := 1; i < len(fragments); i++ {
+			last := i == len(fragments)-1
+			endHeaders := last && (f.EndHeaders == nil || *f.EndHeaders)
+			if err := framer.WriteContinuation(f.StreamID, endHeaders, fragments[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "continuation":
+		endHeaders := f.EndHeaders == nil || *f.EndHeaders
+		return framer.WriteContinuation(f.StreamID, endHeaders, f.Payload)
+
+	case "data":
+		endStream := f.EndStream != nil && *f.EndStream
+		if f.PadLength > 0 {
+			return framer.WriteDataPadded(f.StreamID, endStream, f.Body, make([]byte, f.PadLength))
+		}
+		return framer.WriteData(f.StreamID, endStream, f.Body)
+
+	case "rst_stream":
+		return framer.WriteRSTStream(f.StreamID, http2.ErrCode(f.ErrorCode))
+
+	case "goaway":
+		return framer.WriteGoAway(f.LastStreamID, http2.ErrCode(f.ErrorCode), f.DebugData)
+
+	default:
+		// unknown frame type: emit the raw frame header and payload
+		// verbatim so arbitrary/malformed frame types can be probed.
+		writeRawFrame(buf, f.RawType, f.Flags, f.StreamID, f.Payload)
+		return nil
+	}
+}
+
+// writeRawFrame appends a frame header (RFC 7540 §4.1) and payload directly
+// to buf, bypassing http2.Framer entirely. This is the only way to emit
+// frame types the Framer doesn't know about.
+func writeRawFrame(buf *bytes.Buffer, frameType uint8, flags uint8, streamID uint32, payload []byte) {
+	var lenAndType [4]byte
+	binary.BigEndian.PutUint32(lenAndType[:], uint32(len(payload))<<8|uint32(frameType))
+	buf.Write(lenAndType[:])
+	buf.WriteByte(flags)
+	var streamIDBuf [4]byte
+	binary.BigEndian.PutUint32(streamIDBuf[:], streamID&0x7fffffff)
+	buf.Write(streamIDBuf[:])
+	buf.Write(payload)
+}
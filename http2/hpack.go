@@ -0,0 +1,116 @@
+package http2
+
+import (
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/Martinvks/httptestrunner/utils"
+)
+
+// HeaderField is a single header with explicit control over its HPACK wire
+// representation (RFC 7541 §6.1-6.2), for use in frame scripts. The zero
+// value encodes exactly like the historical hpackAppendHeader(dst, h) did:
+// literal header field never indexed, new name, no Huffman coding.
+type HeaderField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// Representation selects the wire representation: "" (default, as
+	// above), "without_indexing", "incremental_indexing", "never_indexed"
+	// or "indexed".
+	Representation string `json:"representation,omitempty"`
+
+	// NameIndex, when non-zero, encodes the name as a reference into the
+	// static/dynamic table instead of as a literal string. Ignored for
+	// Representation == "indexed", where Index is used instead.
+	NameIndex uint64 `json:"name_index,omitempty"`
+
+	// Index is the full static/dynamic table index used when
+	// Representation == "indexed".
+	Index uint64 `json:"index,omitempty"`
+
+	// HuffmanName and HuffmanValue force Huffman coding of the literal
+	// name/value strings independently. nil means "not Huffman coded",
+	// matching the historical behavior.
+	HuffmanName  *bool `json:"huffman_name,omitempty"`
+	HuffmanValue *bool `json:"huffman_value,omitempty"`
+}
+
+func headerFieldFromUtils(h *utils.Header) HeaderField {
+	return HeaderField{Name: h.Name, Value: h.Value}
+}
+
+// hpackAppendHeader appends the HPACK representation of h to dst.
+func hpackAppendHeader(dst []byte, h *HeaderField) []byte {
+	if h.Representation == "indexed" {
+		return appendPrefixedInt(dst, 7, 0x80, h.Index)
+	}
+
+	var marker byte
+	var prefixBits int
+	switch h.Representation {
+	case "incremental_indexing":
+		marker, prefixBits = 0x40, 6
+	case "without_indexing":
+		marker, prefixBits = 0x00, 4
+	default: // "", "never_indexed": matches the historical 0x10 byte
+		marker, prefixBits = 0x10, 4
+	}
+
+	dst = appendPrefixedInt(dst, prefixBits, marker, h.NameIndex)
+	if h.NameIndex == 0 {
+		dst = appendHpackString(dst, h.Name, boolVal(h.HuffmanName))
+	}
+	return appendHpackString(dst, h.Value, boolVal(h.HuffmanValue))
+}
+
+func appendHpackString(dst []byte, s string, huffman bool) []byte {
+	if huffman {
+		encoded := hpack.AppendHuffmanString(nil, s)
+		dst = appendPrefixedInt(dst, 7, 0x80, uint64(len(encoded)))
+		return append(dst, encoded...)
+	}
+	dst = appendPrefixedInt(dst, 7, 0x00, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func boolVal(b *bool) bool {
+	return b != nil && *b
+}
+
+// appendPrefixedInt appends an RFC 7541 §5.1 prefixed integer: marker
+// already has its representation bits set, and the low prefixBits bits of
+// the first byte carry val (or 2^prefixBits-1 plus a continuation, if val
+// doesn't fit).
+func appendPrefixedInt(dst []byte, prefixBits int, marker byte, val uint64) []byte {
+	max := uint64(1<<uint(prefixBits)) - 1
+	if val < max {
+		return append(dst, marker|byte(val))
+	}
+	dst = append(dst, marker|byte(max))
+	val -= max
+	for val >= 128 {
+		dst = append(dst, byte(0x80|(val&0x7f)))
+		val >>= 7
+	}
+	return append(dst, byte(val))
+}
+
+// splitHeaderBlock splits an encoded header block into len(offsets)+1
+// fragments at the given byte offsets, for use with --continuation-split.
+// Offsets need not align with varint or literal boundaries.
+func splitHeaderBlock(block []byte, offsets []int) [][]byte {
+	fragments := make([][]byte, 0, len(offsets)+1)
+	start := 0
+	for _, off := range offsets {
+		if off < start {
+			off = start
+		}
+		if off > len(block) {
+			off = len(block)
+		}
+		fragments = append(fragments, block[start:off])
+		start = off
+	}
+	fragments = append(fragments, block[start:])
+	return fragments
+}
@@ -15,7 +15,21 @@ import (
 	"github.com/Martinvks/httptestrunner/utils"
 )
 
-func SendHTTP2Request(target *url.URL, timeout time.Duration, keyLogFile string, request utils.HTTPMessage) (utils.HTTPMessage, error) {
+// SendHTTP2Request sends a single HTTP/2 request over a new connection and
+// waits for the response headers and, if present, body. transport selects
+// how the connection is established before HTTP/2 framing begins: over TLS
+// (TransportTLS), as h2c prior knowledge (TransportH2C), or via the
+// HTTP/1.1 Upgrade mechanism (TransportH2CUpgrade). keyLogFile is ignored
+// outside of TransportTLS, since the other two transports are cleartext.
+//
+// If frameScript is non-nil, its frames are sent verbatim in place of the
+// request assembled from request (see FrameScript). continuationSplit, if
+// non-empty, fragments the implicit header block across CONTINUATION
+// frames at the given byte offsets; it is ignored when frameScript is set,
+// since scripts control CONTINUATION framing via the "continuation" frame
+// type directly. If trace is non-nil, every frame read from the connection
+// is recorded to it (see Tracer).
+func SendHTTP2Request(target *url.URL, timeout time.Duration, keyLogFile string, transport Transport, request utils.HTTPMessage, frameScript *FrameScript, continuationSplit []int, trace *Tracer) (utils.HTTPMessage, error) {
 	ip, err := utils.LookUp(target.Hostname())
 	if err != nil {
 		return utils.HTTPMessage{}, err
@@ -23,7 +37,11 @@ func SendHTTP2Request(target *url.URL, timeout time.Duration, keyLogFile string,
 
 	port := target.Port()
 	if port == "" {
-		port = "443"
+		if transport == TransportTLS {
+			port = "443"
+		} else {
+			port = "80"
+		}
 	}
 
 	tcpConn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), port), timeout)
@@ -35,22 +53,48 @@ func SendHTTP2Request(target *url.URL, timeout time.Duration, keyLogFile string,
 	}()
 	_ = tcpConn.SetDeadline(time.Now().Add(timeout))
 
-	var keyLogWriter io.Writer
-	if keyLogFile != "" {
-		keyLogWriter, err = os.OpenFile(keyLogFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	var c net.Conn = tcpConn
+	var framerReader io.Reader = tcpConn
+	streamOneSent := false
+
+	switch transport {
+	case TransportTLS:
+		var keyLogWriter io.Writer
+		if keyLogFile != "" {
+			keyLogWriter, err = os.OpenFile(keyLogFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				return utils.HTTPMessage{}, err
+			}
+		}
+		c = tls.Client(tcpConn, &tls.Config{
+			NextProtos:         []string{"h2"},
+			ServerName:         target.Hostname(),
+			InsecureSkipVerify: true,
+			KeyLogWriter:       keyLogWriter,
+		})
+		framerReader = c
+
+	case TransportH2C:
+		// prior knowledge: nothing to negotiate, the preface is sent as-is.
+
+	case TransportH2CUpgrade:
+		var r io.Reader
+		r, err = upgradeToH2C(tcpConn, target, request)
 		if err != nil {
 			return utils.HTTPMessage{}, err
 		}
-	}
+		framerReader = r
+		streamOneSent = true
 
-	c := tls.Client(tcpConn, &tls.Config{
-		NextProtos:         []string{"h2"},
-		ServerName:         target.Hostname(),
-		InsecureSkipVerify: true,
-		KeyLogWriter:       keyLogWriter,
-	})
+	default:
+		return utils.HTTPMessage{}, fmt.Errorf("unknown transport %v", transport)
+	}
 
-	if _, err := c.Write(prepareHTTP2Request(request)); err != nil {
+	requestBytes, err := buildHTTP2Request(request, frameScript, continuationSplit, streamOneSent)
+	if err != nil {
+		return utils.HTTPMessage{}, err
+	}
+	if _, err := c.Write(requestBytes); err != nil {
 		return utils.HTTPMessage{}, err
 	}
 
@@ -62,7 +106,7 @@ func SendHTTP2Request(target *url.URL, timeout time.Duration, keyLogFile string,
 		)
 	})
 
-	framer := http2.NewFramer(nil, c)
+	framer := http2.NewFramer(nil, framerReader)
 
 	hasBody := false
 	bodyRead := false
@@ -74,6 +118,15 @@ func SendHTTP2Request(target *url.URL, timeout time.Duration, keyLogFile string,
 			return utils.HTTPMessage{}, err
 		}
 
+		switch f := f.(type) {
+		case *http2.HeadersFrame:
+			trace.HeaderBlock(f, f.HeaderBlockFragment())
+		case *http2.ContinuationFrame:
+			trace.HeaderBlock(f, f.HeaderBlockFragment())
+		default:
+			trace.Frame(f)
+		}
+
 		if ga, ok := f.(*http2.GoAwayFrame); ok {
 			return utils.HTTPMessage{}, fmt.Errorf("received GOAWAY frame: error code %v", ga.ErrCode)
 		}
@@ -109,12 +162,27 @@ func SendHTTP2Request(target *url.URL, timeout time.Duration, keyLogFile string,
 	return response, nil
 }
 
-func prepareHTTP2Request(request utils.HTTPMessage) []byte {
-	var hpackBuf []byte
-	for i := range request.Headers {
-		hpackBuf = hpackAppendHeader(hpackBuf, &request.Headers[i])
+// buildHTTP2Request returns the bytes to write to the connection: either the
+// scripted frames from frameScript, or the implicit request built from
+// request when frameScript is nil. streamOneSent is true when
+// TransportH2CUpgrade already sent stream 1's request as the HTTP/1.1
+// Upgrade request, in which case the implicit request consists of only the
+// preface and connection-level frames; a frameScript is sent in full
+// regardless of streamOneSent.
+func buildHTTP2Request(request utils.HTTPMessage, frameScript *FrameScript, continuationSplit []int, streamOneSent bool) ([]byte, error) {
+	if frameScript != nil {
+		return prepareHTTP2RequestFromScript(frameScript)
 	}
+	return prepareHTTP2Request(request, continuationSplit, streamOneSent), nil
+}
 
+// prepareHTTP2Request builds the client preface plus the implicit SETTINGS
+// -> WINDOW_UPDATE -> HEADERS -> DATA sequence for request. If
+// continuationSplit is non-empty, the encoded header block is fragmented
+// into a HEADERS frame followed by one CONTINUATION frame per offset. If
+// streamOneSent is true, the HEADERS/DATA for stream 1 are omitted, since
+// the caller already sent them as an HTTP/1.1 Upgrade request.
+func prepareHTTP2Request(request utils.HTTPMessage, continuationSplit []int, streamOneSent bool) []byte {
 	requestBuf := bytes.NewBuffer(nil)
 	requestBuf.Write([]byte(http2.ClientPreface))
 
@@ -127,46 +195,37 @@ func prepareHTTP2Request(request utils.HTTPMessage) []byte {
 
 	_ = framer.WriteWindowUpdate(0, (1<<30)-(1<<16)-1)
 
-	_ = framer.WriteHeaders(http2.HeadersFrameParam{
-		StreamID:      1,
-		BlockFragment: hpackBuf,
-		EndStream:     len(request.Body) == 0,
-		EndHeaders:    true,
-	})
+	if !streamOneSent {
+		var hpackBuf []byte
+		for i := range request.Headers {
+			h := headerFieldFromUtils(&request.Headers[i])
+			hpackBuf = hpackAppendHeader(hpackBuf, &h)
+		}
+
+		endStream := len(request.Body) == 0
+		fragments := splitHeaderBlock(hpackBuf, continuationSplit)
+		_ = framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      1,
+			BlockFragment: fragments[0],
+			EndStream:     endStream,
+			EndHeaders:    len(fragments) == 1,
+		})
+		for i := 1; i < len(fragments); i++ {
+			_ = framer.WriteContinuation(1, i == len(fragments)-1, fragments[i])
+		}
 
-	start := 0
-	for start < len(request.Body) {
-		end := start + 65536
-		if end > len(request.Body) {
-			end = len(request.Body)
+		start := 0
+		for start < len(request.Body) {
+			end := start + 65536
+			if end > len(request.Body) {
+				end = len(request.Body)
+			}
+			_ = framer.WriteData(1, end == len(request.Body), request.Body[start:end])
+			start = end
 		}
-		_ = framer.WriteData(1, end == len(request.Body), request.Body[start:end])
-		start = end
 	}
 
 	_ = framer.WriteSettingsAck()
 
 	return requestBuf.Bytes()
 }
-
-func hpackAppendHeader(dst []byte, h *utils.Header) []byte {
-	dst = append(dst, 0x10)
-	dst = hpackAppendVarInt(dst, 7, uint64(len(h.Name)))
-	dst = append(dst, h.Name...)
-	dst = hpackAppendVarInt(dst, 7, uint64(len(h.Value)))
-	dst = append(dst, h.Value...)
-	return dst
-}
-
-func hpackAppendVarInt(dst []byte, n byte, val uint64) []byte {
-	k := uint64((1 << n) - 1)
-	if val < k {
-		return append(dst, byte(val))
-	}
-	dst = append(dst, byte(k))
-	val -= k
-	for ; val >= 128; val >>= 7 {
-		dst = append(dst, byte(0x80|(val&0x7f)))
-	}
-	return append(dst, byte(val))
-}
@@ -0,0 +1,115 @@
+package http2
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/Martinvks/httptestrunner/utils"
+)
+
+// MultiStreamScript is the shape of a `multi-stream` script file: an
+// ordered list of actions to drive over a single Connection, each
+// optionally delayed relative to the start of the run (the delay is
+// waited out before the Connection's write lock is acquired), to reproduce
+// timing-sensitive cross-stream behavior.
+type MultiStreamScript struct {
+	Actions []MultiStreamAction `json:"actions"`
+}
+
+// MultiStreamAction describes one action. Only the fields relevant to
+// Action are read.
+//
+//   - "request": send Headers/Body on StreamID and wait for the response.
+//     If ContinuationSplit is non-empty, the encoded header block is split
+//     into a HEADERS frame plus one CONTINUATION frame per offset (see
+//     Connection.SendRequest).
+//   - "raw_headers": send a HEADERS frame on StreamID with RawHeaderBlock
+//     as the block fragment verbatim, without waiting for a response.
+//     Two actions can reference the identical RawHeaderBlock bytes to
+//     reproduce a shared/partial header block across streams.
+//   - "reset": send RST_STREAM on StreamID, without waiting for a
+//     response.
+type MultiStreamAction struct {
+	Action   string        `json:"action"`
+	StreamID uint32        `json:"stream_id"`
+	Delay    time.Duration `json:"delay,omitempty"`
+
+	Headers           []HeaderField `json:"headers,omitempty"`
+	Body              RawBytes      `json:"body,omitempty"`
+	ContinuationSplit []int         `json:"continuation_split,omitempty"`
+
+	RawHeaderBlock RawBytes `json:"raw_header_block,omitempty"`
+	EndStream      bool     `json:"end_stream,omitempty"`
+
+	ErrorCode uint32 `json:"error_code,omitempty"`
+}
+
+// LoadMultiStreamScript reads and parses a multi-stream script file.
+func LoadMultiStreamScript(path string) (*MultiStreamScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script MultiStreamScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("parsing multi-stream script: %w", err)
+	}
+	return &script, nil
+}
+
+// RunMultiStreamScript launches script's actions against conn concurrently,
+// one goroutine per action, so their StreamSpec.Delay values control the
+// relative order frames hit the wire instead of script order (see
+// StreamSpec.Delay). It collects a response for each "request" action;
+// "raw_headers" and "reset" actions don't wait for a response and have a
+// nil entry in the returned slice. If any action errors, the error for the
+// lowest-indexed failing action is returned alongside whatever responses
+// were collected.
+func RunMultiStreamScript(conn *Connection, script *MultiStreamScript) ([]*utils.HTTPMessage, error) {
+	responses := make([]*utils.HTTPMessage, len(script.Actions))
+	errs := make([]error, len(script.Actions))
+
+	var wg sync.WaitGroup
+	for i, a := range script.Actions {
+		wg.Add(1)
+		go func(i int, a MultiStreamAction) {
+			defer wg.Done()
+			stream := StreamSpec{ID: a.StreamID, Delay: a.Delay}
+			switch a.Action {
+			case "request":
+				msg, err := conn.SendRequest(stream, a.Headers, a.Body, a.ContinuationSplit)
+				if err != nil {
+					errs[i] = fmt.Errorf("action %d (request on stream %d): %w", i, a.StreamID, err)
+					return
+				}
+				responses[i] = &msg
+
+			case "raw_headers":
+				if err := conn.SendRawHeaders(stream, a.RawHeaderBlock, a.EndStream); err != nil {
+					errs[i] = fmt.Errorf("action %d (raw_headers on stream %d): %w", i, a.StreamID, err)
+				}
+
+			case "reset":
+				if err := conn.ResetStream(stream, http2.ErrCode(a.ErrorCode)); err != nil {
+					errs[i] = fmt.Errorf("action %d (reset on stream %d): %w", i, a.StreamID, err)
+				}
+
+			default:
+				errs[i] = fmt.Errorf("action %d: unknown action %q", i, a.Action)
+			}
+		}(i, a)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return responses, err
+		}
+	}
+	return responses, nil
+}
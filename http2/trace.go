@@ -0,0 +1,354 @@
+package http2
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// Tracer records every frame observed on an HTTP/2 connection as a JSON
+// line, for `--trace`. Each line is tagged with TimeNs, nanoseconds since
+// the Tracer was created, so a recorded frame can be correlated with the
+// side effects it caused.
+type Tracer struct {
+	w     io.Writer
+	start time.Time
+}
+
+// NewTracer returns a Tracer that writes JSON lines to w, timestamped
+// relative to now.
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{w: w, start: time.Now()}
+}
+
+// traceEvent is the JSON shape of one recorded frame. Only the fields
+// relevant to Type are populated.
+type traceEvent struct {
+	TimeNs   int64  `json:"time_ns"`
+	Type     string `json:"type"`
+	StreamID uint32 `json:"stream_id"`
+	Flags    uint8  `json:"flags"`
+	Length   uint32 `json:"length"`
+
+	Settings     []SettingSpec       `json:"settings,omitempty"`
+	Increment    uint32              `json:"increment,omitempty"`
+	ErrorCode    uint32              `json:"error_code,omitempty"`
+	LastStreamID uint32              `json:"last_stream_id,omitempty"`
+	DebugData    string              `json:"debug_data,omitempty"`
+	PingData     string              `json:"ping_data,omitempty"`
+	Ack          bool                `json:"ack,omitempty"`
+	StreamDep    uint32              `json:"stream_dep,omitempty"`
+	Exclusive    bool                `json:"exclusive,omitempty"`
+	Weight       uint8               `json:"weight,omitempty"`
+	EndStream    bool                `json:"end_stream,omitempty"`
+	EndHeaders   bool                `json:"end_headers,omitempty"`
+	Fields       []tracedHeaderField `json:"fields,omitempty"`
+}
+
+// tracedHeaderField is one HPACK field line decoded from a HEADERS or
+// CONTINUATION frame's block fragment.
+type tracedHeaderField struct {
+	Name           string `json:"name,omitempty"`
+	Value          string `json:"value,omitempty"`
+	Representation string `json:"representation"`
+	Index          uint64 `json:"index,omitempty"`
+}
+
+func (t *Tracer) emit(ev traceEvent) {
+	if t == nil {
+		return
+	}
+	ev.TimeNs = time.Since(t.start).Nanoseconds()
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	_, _ = t.w.Write(append(data, '\n'))
+}
+
+// Frame records a single non-HEADERS/CONTINUATION frame. Call HeaderBlock
+// for HEADERS/CONTINUATION frames instead, since their payload needs
+// HPACK decoding rather than the type-specific decoding done here.
+func (t *Tracer) Frame(f http2.Frame) {
+	if t == nil {
+		return
+	}
+	h := f.Header()
+	ev := traceEvent{
+		Type:     h.Type.String(),
+		StreamID: h.StreamID,
+		Flags:    uint8(h.Flags),
+		Length:   h.Length,
+	}
+	switch f := f.(type) {
+	case *http2.SettingsFrame:
+		_ = f.ForeachSetting(func(s http2.Setting) error {
+			ev.Settings = append(ev.Settings, SettingSpec{ID: uint16(s.ID), Val: s.Val})
+			return nil
+		})
+	case *http2.WindowUpdateFrame:
+		ev.Increment = f.Increment
+	case *http2.RSTStreamFrame:
+		ev.ErrorCode = uint32(f.ErrCode)
+	case *http2.GoAwayFrame:
+		ev.ErrorCode = uint32(f.ErrCode)
+		ev.LastStreamID = f.LastStreamID
+		ev.DebugData = hex.EncodeToString(f.DebugData())
+	case *http2.PingFrame:
+		ev.PingData = hex.EncodeToString(f.Data[:])
+		ev.Ack = f.IsAck()
+	case *http2.PriorityFrame:
+		ev.StreamDep = f.PriorityParam.StreamDep
+		ev.Exclusive = f.PriorityParam.Exclusive
+		ev.Weight = f.PriorityParam.Weight
+	case *http2.DataFrame:
+		ev.EndStream = f.StreamEnded()
+	}
+	t.emit(ev)
+}
+
+// HeaderBlock records one HEADERS or CONTINUATION frame, decoding its block
+// fragment's HPACK field lines (RFC 7541 §6) with their wire representation
+// and index, independent of whatever stateful hpack.Decoder the caller also
+// feeds the same bytes to for header resolution.
+func (t *Tracer) HeaderBlock(f http2.Frame, block []byte) {
+	if t == nil {
+		return
+	}
+	h := f.Header()
+	ev := traceEvent{
+		Type:     h.Type.String(),
+		StreamID: h.StreamID,
+		Flags:    uint8(h.Flags),
+		Length:   h.Length,
+		Fields:   decodeHpackTrace(block),
+	}
+	switch f := f.(type) {
+	case *http2.HeadersFrame:
+		ev.EndStream = f.StreamEnded()
+		ev.EndHeaders = f.HeadersEnded()
+		if f.HasPriority() {
+			ev.StreamDep = f.Priority.StreamDep
+			ev.Exclusive = f.Priority.Exclusive
+			ev.Weight = f.Priority.Weight
+		}
+	case *http2.ContinuationFrame:
+		ev.EndHeaders = f.HeadersEnded()
+	}
+	t.emit(ev)
+}
+
+// decodeHpackTrace walks block and returns one tracedHeaderField per field
+// line representation (RFC 7541 §6.1-6.2); dynamic table size updates
+// (§6.3) are skipped and produce no entry. Literal strings are resolved
+// (Huffman-decoded if needed) but dynamic table lookups are not: an entry
+// whose index falls outside the static table (index > 61) has an empty
+// Name, and for "indexed" entries an empty Value as well. Malformed
+// trailing bytes are silently dropped, since this is best-effort
+// diagnostic output.
+func decodeHpackTrace(block []byte) []tracedHeaderField {
+	var fields []tracedHeaderField
+	for len(block) > 0 {
+		b0 := block[0]
+		switch {
+		case b0&0x80 != 0: // indexed header field, RFC 7541 §6.1
+			idx, n := readPrefixedInt(block, 7)
+			if n == 0 {
+				return fields
+			}
+			block = block[n:]
+			name, value := staticTableLookup(idx)
+			fields = append(fields, tracedHeaderField{
+				Name: name, Value: value, Representation: "indexed", Index: idx,
+			})
+
+		case b0&0xc0 == 0x40: // literal with incremental indexing, §6.2.1
+			f, rest, ok := decodeHpackLiteral(block, 6, "literal_incremental_indexing")
+			if !ok {
+				return fields
+			}
+			fields = append(fields, f)
+			block = rest
+
+		case b0&0xe0 == 0x20: // dynamic table size update, §6.3
+			_, n := readPrefixedInt(block, 5)
+			if n == 0 {
+				return fields
+			}
+			block = block[n:]
+
+		case b0&0xf0 == 0x10: // literal never indexed, §6.2.3
+			f, rest, ok := decodeHpackLiteral(block, 4, "literal_never_indexed")
+			if !ok {
+				return fields
+			}
+			fields = append(fields, f)
+			block = rest
+
+		default: // literal without indexing, §6.2.2
+			f, rest, ok := decodeHpackLiteral(block, 4, "literal_without_indexing")
+			if !ok {
+				return fields
+			}
+			fields = append(fields, f)
+			block = rest
+		}
+	}
+	return fields
+}
+
+func decodeHpackLiteral(block []byte, prefixBits int, representation string) (tracedHeaderField, []byte, bool) {
+	idx, n := readPrefixedInt(block, prefixBits)
+	if n == 0 {
+		return tracedHeaderField{}, nil, false
+	}
+	block = block[n:]
+
+	name, ok := "", false
+	if idx == 0 {
+		var s string
+		s, block, ok = readHpackString(block)
+		name = s
+	} else {
+		name, _ = staticTableLookup(idx)
+		ok = true
+	}
+	if !ok {
+		return tracedHeaderField{}, nil, false
+	}
+
+	value, rest, ok := readHpackString(block)
+	if !ok {
+		return tracedHeaderField{}, nil, false
+	}
+	return tracedHeaderField{
+		Name: name, Value: value, Representation: representation, Index: idx,
+	}, rest, true
+}
+
+// readHpackString reads a single HPACK string literal (RFC 7541 §5.2),
+// Huffman-decoding it if its H bit is set.
+func readHpackString(block []byte) (s string, rest []byte, ok bool) {
+	if len(block) == 0 {
+		return "", nil, false
+	}
+	huffman := block[0]&0x80 != 0
+	length, n := readPrefixedInt(block, 7)
+	if n == 0 || uint64(n)+length > uint64(len(block)) {
+		return "", nil, false
+	}
+	raw := block[n : uint64(n)+length]
+	rest = block[uint64(n)+length:]
+	if !huffman {
+		return string(raw), rest, true
+	}
+	decoded, err := hpack.HuffmanDecodeToString(raw)
+	if err != nil {
+		return "", nil, false
+	}
+	return decoded, rest, true
+}
+
+// readPrefixedInt is the inverse of appendPrefixedInt: it reads an RFC 7541
+// §5.1 prefixed integer from the low prefixBits bits of block[0] onward,
+// returning the decoded value and the number of bytes consumed (0 on
+// truncated/malformed input).
+func readPrefixedInt(block []byte, prefixBits int) (val uint64, consumed int) {
+	if len(block) == 0 {
+		return 0, 0
+	}
+	max := uint64(1<<uint(prefixBits)) - 1
+	val = uint64(block[0]) & max
+	if val < max {
+		return val, 1
+	}
+	shift := uint(0)
+	for i := 1; i < len(block); i++ {
+		b := block[i]
+		val += uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return val, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// staticTableLookup resolves idx (1-based) against the HPACK static table
+// (RFC 7541 appendix A). It returns an empty name and value for dynamic
+// table indices (idx > 61), which this best-effort tracer does not resolve.
+func staticTableLookup(idx uint64) (name, value string) {
+	if idx < 1 || int(idx) > len(staticTable) {
+		return "", ""
+	}
+	e := staticTable[idx-1]
+	return e.name, e.value
+}
+
+var staticTable = [...]struct{ name, value string }{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
@@ -0,0 +1,142 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http2"
+
+	"github.com/Martinvks/httptestrunner/utils"
+)
+
+// Transport selects how SendHTTP2Request establishes the connection before
+// speaking HTTP/2 on it.
+type Transport int
+
+const (
+	// TransportTLS negotiates h2 over TLS via ALPN (the historical, and
+	// still default, behavior).
+	TransportTLS Transport = iota
+	// TransportH2C speaks h2c: the client preface is sent directly over
+	// plaintext TCP, with no negotiation ("prior knowledge", RFC 7540
+	// §3.4).
+	TransportH2C
+	// TransportH2CUpgrade speaks h2c via the HTTP/1.1 Upgrade mechanism
+	// (RFC 7540 §3.2): an HTTP/1.1 request carrying Connection: Upgrade,
+	// HTTP2-Settings: <base64url SETTINGS>, Upgrade: h2c is sent first,
+	// and on a 101 response the connection continues with the preface.
+	// The HTTP/1.1 request stands in for stream 1, so the implicit
+	// HEADERS/DATA for stream 1 are not sent again.
+	TransportH2CUpgrade
+)
+
+// upgradeToH2C performs the HTTP/1.1 Upgrade handshake (RFC 7540 §3.2) on
+// conn: it sends a request built from request's pseudo-headers (falling
+// back to GET, "/" and target's host when absent) carrying the Upgrade, Connection
+// and HTTP2-Settings header fields plus request.Body. A Content-Length
+// matching the body is added unless request.Headers already sets one, so a
+// script testing a deliberately mismatched/duplicate Content-Length isn't
+// overridden. It then reads the HTTP/1.1 response line and headers,
+// returning an error unless the status is 101. The returned io.Reader
+// continues where the response headers left off, so the caller must read
+// the rest of the connection (the HTTP/2 preface and frames) through it
+// rather than through conn directly, since bytes the peer flushed right
+// after the 101 response may already sit in its internal buffer.
+func upgradeToH2C(conn net.Conn, target *url.URL, request utils.HTTPMessage) (io.Reader, error) {
+	method, path, host, headers := splitPseudoHeaders(target, request.Headers)
+
+	settingsPayload := &bytes.Buffer{}
+	binarySettings(settingsPayload, http2.Setting{ID: http2.SettingInitialWindowSize, Val: (1 << 30) - 1})
+	encodedSettings := base64.RawURLEncoding.EncodeToString(settingsPayload.Bytes())
+
+	req := &bytes.Buffer{}
+	fmt.Fprintf(req, "%s %s HTTP/1.1\r\n", method, path)
+	fmt.Fprintf(req, "Host: %s\r\n", host)
+	fmt.Fprintf(req, "Connection: Upgrade, HTTP2-Settings\r\n")
+	fmt.Fprintf(req, "Upgrade: h2c\r\n")
+	fmt.Fprintf(req, "HTTP2-Settings: %s\r\n", encodedSettings)
+	if len(request.Body) > 0 && !hasHeader(headers, "content-length") {
+		fmt.Fprintf(req, "Content-Length: %d\r\n", len(request.Body))
+	}
+	for _, h := range headers {
+		fmt.Fprintf(req, "%s: %s\r\n", h.Name, h.Value)
+	}
+	req.WriteString("\r\n")
+	req.Write(request.Body)
+
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	statusLine, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("reading upgrade response: %w", err)
+	}
+	if !strings.Contains(statusLine, " 101 ") {
+		return nil, fmt.Errorf("upgrade rejected: %s", strings.TrimSpace(statusLine))
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading upgrade response: %w", err)
+		}
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+	}
+	return r, nil
+}
+
+// hasHeader reports whether headers already contains a field named name,
+// case-insensitively.
+func hasHeader(headers []utils.Header, name string) bool {
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPseudoHeaders pulls apart the ":method", ":path" and ":authority"
+// pseudo-headers (falling back to GET, "/" and target's host), returning
+// the remaining regular headers unchanged and in order.
+func splitPseudoHeaders(target *url.URL, h []utils.Header) (method, path, host string, rest []utils.Header) {
+	method, path, host = "GET", "/", target.Host
+	for _, header := range h {
+		switch header.Name {
+		case ":method":
+			method = header.Value
+		case ":path":
+			path = header.Value
+		case ":authority":
+			host = header.Value
+		default:
+			rest = append(rest, header)
+		}
+	}
+	return
+}
+
+// binarySettings appends the RFC 7540 §6.5.1 wire payload of settings (6
+// bytes each: a 2-byte ID, a 4-byte value), the same format a SETTINGS
+// frame's payload uses, for use in the HTTP2-Settings header field.
+func binarySettings(buf *bytes.Buffer, settings ...http2.Setting) {
+	for _, s := range settings {
+		var b [6]byte
+		b[0] = byte(s.ID >> 8)
+		b[1] = byte(s.ID)
+		b[2] = byte(s.Val >> 24)
+		b[3] = byte(s.Val >> 16)
+		b[4] = byte(s.Val >> 8)
+		b[5] = byte(s.Val)
+		buf.Write(b[:])
+	}
+}
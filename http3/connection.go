@@ -0,0 +1,164 @@
+package http3
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+
+	"github.com/Martinvks/httptestrunner/utils"
+)
+
+// StreamSpec paces a Connection.SendRequest call relative to others on the
+// same Connection. Unlike http2.StreamSpec, ID is informational only: the
+// QUIC transport assigns each client-initiated bidirectional stream the
+// next available ID (0, 4, 8, ...) and quic-go does not expose a way to
+// override that, so arbitrary/reused HTTP/3 stream IDs aren't reproducible
+// through this Connection.
+type StreamSpec struct {
+	ID uint64
+	// Delay is how long to wait, before acquiring the connection's write
+	// lock, before opening and writing this request's stream. Waiting
+	// happens before the lock is taken so concurrent calls with different
+	// Delay values race for the lock in Delay order.
+	Delay time.Duration
+}
+
+// Connection is a single QUIC session shared across multiple logical
+// HTTP/3 requests, each on its own request stream. Unlike
+// SendHTTP3Request, it does not close the session after one request.
+type Connection struct {
+	session           quic.Connection
+	udpConn           net.PacketConn
+	peers             *peerStreams
+	timeout           time.Duration
+	trace             *Tracer
+	dynamicEntryCount int
+
+	writeMu sync.Mutex
+}
+
+// DialConnection opens the QUIC session, performs the control/QPACK stream
+// setup (see openLocalStreams), and starts accepting the peer's
+// unidirectional streams in the background. If trace is non-nil, every
+// unidirectional stream type, control-stream SETTINGS and request-stream
+// frame observed is recorded to it (see Tracer).
+func DialConnection(
+	target *url.URL,
+	timeout time.Duration,
+	keyLogWriter io.Writer,
+	localSettings []SettingSpec,
+	encoderInstructions []DynamicTableEntry,
+	trace *Tracer,
+) (*Connection, error) {
+	ip, err := utils.LookUp(target.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	port := target.Port()
+	if port == "" {
+		port = "443"
+	}
+	portInt, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %w", err)
+	}
+
+	udpConn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+
+	udpAddr := &net.UDPAddr{IP: ip, Port: portInt}
+
+	tlsConfig := &tls.Config{
+		NextProtos:         []string{"h3", "h3-29"},
+		ServerName:         target.Hostname(),
+		InsecureSkipVerify: true,
+		KeyLogWriter:       keyLogWriter,
+	}
+	quicConfig := &quic.Config{
+		Versions:           []quic.VersionNumber{quic.Version1, quic.VersionDraft29},
+		MaxIncomingStreams: -1,
+	}
+
+	dialCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	session, err := quic.DialEarlyContext(dialCtx, udpConn, udpAddr, target.Hostname(), tlsConfig, quicConfig)
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, err
+	}
+
+	if err := openLocalStreams(session, nil, localSettings, buildQpackEncoderInstructions(encoderInstructions)); err != nil {
+		_ = session.CloseWithError(0, "")
+		_ = udpConn.Close()
+		return nil, err
+	}
+
+	peers := newPeerStreams(trace)
+	go peers.run(context.Background(), session)
+
+	return &Connection{
+		session:           session,
+		udpConn:           udpConn,
+		peers:             peers,
+		timeout:           timeout,
+		trace:             trace,
+		dynamicEntryCount: len(encoderInstructions),
+	}, nil
+}
+
+// Close closes the underlying QUIC session and socket.
+func (c *Connection) Close() error {
+	_ = c.session.CloseWithError(0, "")
+	return c.udpConn.Close()
+}
+
+// PeerSettings returns the SETTINGS received so far on the peer's control
+// stream.
+func (c *Connection) PeerSettings() map[uint64]uint64 {
+	return c.peers.Settings()
+}
+
+// SendRequest opens a new request stream, writes headers and body, and
+// waits for the response. Each header field is encoded with its own QPACK
+// representation (see QpackHeaderField), so a multi-stream script can
+// reference entries pre-populated via --qpack-insert by Index/NameIndex,
+// unlike the plain types.Header the implicit request path uses.
+func (c *Connection) SendRequest(stream StreamSpec, headers []QpackHeaderField, body []byte) (*Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if stream.Delay > 0 {
+		time.Sleep(stream.Delay)
+	}
+	c.writeMu.Lock()
+	requestStream, err := c.session.OpenStream()
+	if err != nil {
+		c.writeMu.Unlock()
+		return nil, err
+	}
+	for _, f := range prepareHTTP3RequestFields(headers, body, c.dynamicEntryCount) {
+		_, _ = requestStream.Write(f)
+	}
+	closeErr := requestStream.Close()
+	c.writeMu.Unlock()
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	respHeaders, respBody, err := readResponse(ctx, requestStream, c.trace)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Headers: respHeaders, Body: respBody, PeerSettings: c.peers.Settings()}, nil
+}
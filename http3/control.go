@@ -0,0 +1,178 @@
+package http3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// HTTP/3 SETTINGS identifiers defined by RFC 9114 §7.2.4.1 / RFC 9204 §5.
+const (
+	SettingQpackMaxTableCapacity = 0x1
+	SettingMaxFieldSectionSize   = 0x6
+	SettingQpackBlockedStreams   = 0x7
+)
+
+// Unidirectional stream types (RFC 9114 §6.2, RFC 9204 §4.2).
+const (
+	streamTypeControl      = 0x00
+	streamTypeQpackEncoder = 0x02
+	streamTypeQpackDecoder = 0x03
+)
+
+// openLocalStreams opens this endpoint's control, QPACK encoder and QPACK
+// decoder unidirectional streams (RFC 9114 §6.2.1, RFC 9204 §4.2).
+//
+// The control stream carries controlFrames verbatim if non-empty, or a
+// single SETTINGS frame built from localSettings (which may be empty,
+// matching the historical behavior of sending no parameters) otherwise.
+// encoderInstructions, if non-empty, is written to the QPACK encoder
+// stream to pre-populate the peer's dynamic table.
+func openLocalStreams(session quic.Connection, controlFrames [][]byte, localSettings []SettingSpec, encoderInstructions []byte) error {
+	control, err := session.OpenUniStream()
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	writeVarInt(buf, streamTypeControl)
+	if len(controlFrames) > 0 {
+		for _, f := range controlFrames {
+			buf.Write(f)
+		}
+	} else {
+		settingsFrame, err := buildSettingsFrame(localSettings)
+		if err != nil {
+			return err
+		}
+		buf.Write(settingsFrame)
+	}
+	if _, err := control.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	encoder, err := session.OpenUniStream()
+	if err != nil {
+		return err
+	}
+	encBuf := &bytes.Buffer{}
+	writeVarInt(encBuf, streamTypeQpackEncoder)
+	encBuf.Write(encoderInstructions)
+	if _, err := encoder.Write(encBuf.Bytes()); err != nil {
+		return err
+	}
+
+	decoder, err := session.OpenUniStream()
+	if err != nil {
+		return err
+	}
+	decBuf := &bytes.Buffer{}
+	writeVarInt(decBuf, streamTypeQpackDecoder)
+	if _, err := decoder.Write(decBuf.Bytes()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// buildSettingsFrame encodes settings into a SETTINGS frame, validating
+// each ID and value fits into a QUIC varint first, since settings can come
+// straight from user input (--h3-setting).
+func buildSettingsFrame(settings []SettingSpec) ([]byte, error) {
+	payload := bytes.NewBuffer(nil)
+	for _, s := range settings {
+		if err := validateVarInt(s.ID); err != nil {
+			return nil, fmt.Errorf("setting id: %w", err)
+		}
+		if err := validateVarInt(s.Val); err != nil {
+			return nil, fmt.Errorf("setting value: %w", err)
+		}
+		writeVarInt(payload, s.ID)
+		writeVarInt(payload, s.Val)
+	}
+	return frame(0x4, payload.Bytes()), nil
+}
+
+// peerStreams accepts and classifies the peer's unidirectional streams
+// (control, QPACK encoder, QPACK decoder, or unknown) for as long as ctx is
+// not done, recording any SETTINGS received on the peer's control stream.
+// QPACK encoder/decoder streams are drained but otherwise ignored, since
+// this client never references the peer's dynamic table.
+type peerStreams struct {
+	mu       sync.Mutex
+	settings map[uint64]uint64
+	trace    *Tracer
+}
+
+func newPeerStreams(trace *Tracer) *peerStreams {
+	return &peerStreams{settings: make(map[uint64]uint64), trace: trace}
+}
+
+func (p *peerStreams) Settings() map[uint64]uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(map[uint64]uint64, len(p.settings))
+	for k, v := range p.settings {
+		out[k] = v
+	}
+	return out
+}
+
+func (p *peerStreams) run(ctx context.Context, session quic.Connection) {
+	for {
+		stream, err := session.AcceptUniStream(ctx)
+		if err != nil {
+			return
+		}
+		go p.handle(stream)
+	}
+}
+
+func (p *peerStreams) handle(stream quic.ReceiveStream) {
+	b := readerFor(stream)
+	streamType, err := readVarInt(b)
+	if err != nil {
+		return
+	}
+	p.trace.UniStream(streamType)
+	switch streamType {
+	case streamTypeControl:
+		p.readControlStream(b)
+	default:
+		// QPACK encoder/decoder streams and anything unrecognized: drain
+		// so the peer isn't blocked on flow control, but otherwise ignore.
+		_, _ = io.Copy(io.Discard, b)
+	}
+}
+
+func (p *peerStreams) readControlStream(b byteReader) {
+	for {
+		f, err := readFrame(b)
+		if err != nil {
+			return
+		}
+		if f.Type != 0x4 {
+			continue
+		}
+		payload := bytes.NewReader(f.Data)
+		frameSettings := make(map[uint64]uint64)
+		for payload.Len() > 0 {
+			id, err := readVarInt(payload)
+			if err != nil {
+				return
+			}
+			val, err := readVarInt(payload)
+			if err != nil {
+				return
+			}
+			frameSettings[id] = val
+			p.mu.Lock()
+			p.settings[id] = val
+			p.mu.Unlock()
+		}
+		p.trace.ControlSettings(frameSettings)
+	}
+}
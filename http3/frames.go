@@ -0,0 +1,173 @@
+package http3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RawBytes is a byte slice that can be specified in a frame script either as
+// a plain JSON string (interpreted as its literal bytes) or, when prefixed
+// with "hex:", as a hex-encoded string.
+type RawBytes []byte
+
+func (r *RawBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if rest, ok := strings.CutPrefix(s, "hex:"); ok {
+		b, err := hex.DecodeString(rest)
+		if err != nil {
+			return fmt.Errorf("invalid hex payload: %w", err)
+		}
+		*r = b
+		return nil
+	}
+	*r = []byte(s)
+	return nil
+}
+
+// FrameScript is the top level shape of a `--frames` file for HTTP/3: an
+// ordered list of frames written to the request stream, plus an optional
+// ordered list of frames written to the local control stream in place of
+// the default SETTINGS frame.
+type FrameScript struct {
+	ControlFrames []FrameSpec `json:"control_frames,omitempty"`
+	RequestFrames []FrameSpec `json:"request_frames"`
+}
+
+// FrameSpec describes a single HTTP/3 frame. Only the fields relevant to
+// Type are read.
+type FrameSpec struct {
+	Type string `json:"type"`
+
+	// data
+	Body RawBytes `json:"body,omitempty"`
+
+	// headers
+	Headers []QpackHeaderField `json:"headers,omitempty"`
+
+	// settings
+	Settings []SettingSpec `json:"settings,omitempty"`
+
+	// goaway
+	StreamID uint64 `json:"stream_id,omitempty"`
+
+	// cancel_push / max_push_id
+	PushID uint64 `json:"push_id,omitempty"`
+
+	// unknown/reserved frame types
+	RawType uint64   `json:"raw_type,omitempty"`
+	Payload RawBytes `json:"payload,omitempty"`
+}
+
+// SettingSpec is a single HTTP/3 SETTINGS parameter. ID is a raw varint, not
+// restricted to the known identifiers, so reserved/GREASE values (RFC 9114
+// §7.2.4.1) can be scripted.
+type SettingSpec struct {
+	ID  uint64 `json:"id"`
+	Val uint64 `json:"val"`
+}
+
+// LoadFrameScript reads and parses a frame script file, as passed to
+// `--frames`.
+func LoadFrameScript(path string) (*FrameScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script FrameScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("parsing frame script: %w", err)
+	}
+	return &script, nil
+}
+
+// buildFrame encodes a single scripted frame to its wire representation,
+// including the leading type and length varints (RFC 9114 §7.2), except for
+// "raw" frames which may carry an arbitrary/reserved type. dynamicEntryCount
+// is the number of entries pre-populated via --qpack-insert, used to fill in
+// a "headers" frame's field section prefix (see appendFieldSectionPrefix).
+func buildFrame(f *FrameSpec, dynamicEntryCount int) ([]byte, error) {
+	switch f.Type {
+	case "data":
+		return frame(0x0, f.Body), nil
+
+	case "headers":
+		qpackBuf := appendFieldSectionPrefix(nil, dynamicEntryCount)
+		for i := range f.Headers {
+			qpackBuf = encodeQpackField(qpackBuf, &f.Headers[i], dynamicEntryCount)
+		}
+		return frame(0x1, qpackBuf), nil
+
+	case "settings":
+		payload := bytes.NewBuffer(nil)
+		for _, s := range f.Settings {
+			if err := validateVarInt(s.ID); err != nil {
+				return nil, fmt.Errorf("setting id: %w", err)
+			}
+			if err := validateVarInt(s.Val); err != nil {
+				return nil, fmt.Errorf("setting value: %w", err)
+			}
+			writeVarInt(payload, s.ID)
+			writeVarInt(payload, s.Val)
+		}
+		return frame(0x4, payload.Bytes()), nil
+
+	case "goaway":
+		if err := validateVarInt(f.StreamID); err != nil {
+			return nil, fmt.Errorf("stream id: %w", err)
+		}
+		payload := bytes.NewBuffer(nil)
+		writeVarInt(payload, f.StreamID)
+		return frame(0x7, payload.Bytes()), nil
+
+	case "cancel_push":
+		if err := validateVarInt(f.PushID); err != nil {
+			return nil, fmt.Errorf("push id: %w", err)
+		}
+		payload := bytes.NewBuffer(nil)
+		writeVarInt(payload, f.PushID)
+		return frame(0x3, payload.Bytes()), nil
+
+	case "max_push_id":
+		if err := validateVarInt(f.PushID); err != nil {
+			return nil, fmt.Errorf("push id: %w", err)
+		}
+		payload := bytes.NewBuffer(nil)
+		writeVarInt(payload, f.PushID)
+		return frame(0xd, payload.Bytes()), nil
+
+	default:
+		// unknown/reserved frame type: raw_type and payload verbatim.
+		if err := validateVarInt(f.RawType); err != nil {
+			return nil, fmt.Errorf("raw_type: %w", err)
+		}
+		return frame(f.RawType, f.Payload), nil
+	}
+}
+
+func frame(frameType uint64, payload []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	writeVarInt(buf, frameType)
+	writeVarInt(buf, uint64(len(payload)))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// buildFrames encodes each frame in specs in order.
+func buildFrames(specs []FrameSpec, dynamicEntryCount int) ([][]byte, error) {
+	frames := make([][]byte, len(specs))
+	for i := range specs {
+		f, err := buildFrame(&specs[i], dynamicEntryCount)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d (%s): %w", i, specs[i].Type, err)
+		}
+		frames[i] = f
+	}
+	return frames, nil
+}
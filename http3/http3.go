@@ -18,7 +18,36 @@ import (
 	"github.com/marten-seemann/qpack"
 )
 
-func SendHTTP3Request(target *url.URL, timeout time.Duration, keyLogWriter io.Writer, request *types.HttpRequest) (*types.HttpResponse, error) {
+// Response is the result of SendHTTP3Request: the response headers and
+// body, plus any SETTINGS the peer sent on its control stream.
+type Response struct {
+	Headers      []types.Header
+	Body         []byte
+	PeerSettings map[uint64]uint64
+}
+
+// SendHTTP3Request sends a single HTTP/3 request over a new QUIC connection
+// and waits for the response headers and, if present, body.
+//
+// If frameScript is non-nil, its request_frames are sent verbatim on the
+// request stream in place of the request assembled from request, and its
+// control_frames (if any) replace the default SETTINGS frame on the local
+// control stream (see FrameScript). Otherwise, the local control stream's
+// SETTINGS frame is built from localSettings. encoderInstructions, if
+// non-empty, pre-populates the peer's QPACK dynamic table over the QPACK
+// encoder stream. If trace is non-nil, every unidirectional stream type,
+// control-stream SETTINGS and request-stream frame observed is recorded to
+// it (see Tracer).
+func SendHTTP3Request(
+	target *url.URL,
+	timeout time.Duration,
+	keyLogWriter io.Writer,
+	request *types.HttpRequest,
+	frameScript *FrameScript,
+	localSettings []SettingSpec,
+	encoderInstructions []DynamicTableEntry,
+	trace *Tracer,
+) (*Response, error) {
 	ip, err := utils.LookUp(target.Hostname())
 	if err != nil {
 		return nil, err
@@ -71,15 +100,34 @@ func SendHTTP3Request(target *url.URL, timeout time.Duration, keyLogWriter io.Wr
 	}
 	defer func() { _ = session.CloseWithError(0, "") }()
 
-	if err := setupSession(session); err != nil {
+	var controlFrames [][]byte
+	if frameScript != nil && frameScript.ControlFrames != nil {
+		controlFrames, err = buildFrames(frameScript.ControlFrames, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := openLocalStreams(session, controlFrames, localSettings, buildQpackEncoderInstructions(encoderInstructions)); err != nil {
 		return nil, err
 	}
+
+	peers := newPeerStreams(trace)
+	go peers.run(ctx, session)
+
 	requestStream, err := session.OpenStream()
 	if err != nil {
 		return nil, err
 	}
 
-	frames := prepareHTTP3Request(request)
+	var frames [][]byte
+	if frameScript != nil {
+		frames, err = buildFrames(frameScript.RequestFrames, len(encoderInstructions))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		frames = prepareHTTP3Request(request, len(encoderInstructions))
+	}
 	for _, f := range frames {
 		_, _ = requestStream.Write(f)
 	}
@@ -88,6 +136,21 @@ func SendHTTP3Request(target *url.URL, timeout time.Duration, keyLogWriter io.Wr
 		return nil, err
 	}
 
+	headers, body, err := readResponse(ctx, requestStream, trace)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		Headers:      headers,
+		Body:         body,
+		PeerSettings: peers.Settings(),
+	}, nil
+}
+
+// readResponse reads request/response stream frames until the stream is
+// closed by the peer, decoding HEADERS and accumulating DATA.
+func readResponse(ctx context.Context, stream quic.Stream, trace *Tracer) ([]types.Header, []byte, error) {
 	var (
 		headers []types.Header
 		body    []byte
@@ -98,13 +161,13 @@ func SendHTTP3Request(target *url.URL, timeout time.Duration, keyLogWriter io.Wr
 			Value: f.Value,
 		})
 	})
-	b := bufio.NewReader(requestStream)
+	b := bufio.NewReader(stream)
 
 	for {
 		frame, err := readFrame(b)
 		if err != nil {
 			if ctx.Err() != nil {
-				return nil, fmt.Errorf("timeout")
+				return nil, nil, fmt.Errorf("timeout")
 			}
 
 			if err == io.EOF {
@@ -113,27 +176,26 @@ func SendHTTP3Request(target *url.URL, timeout time.Duration, keyLogWriter io.Wr
 
 			if qErr, ok := err.(interface{ IsApplicationError() bool }); ok {
 				if qErr.IsApplicationError() {
-					return nil, fmt.Errorf("ConnDropError{err}")
+					return nil, nil, fmt.Errorf("ConnDropError{err}")
 				}
 			}
-			return nil, err
+			return nil, nil, err
 		}
+		trace.RequestFrame(frame)
+
 		switch frame.Type {
 		case 0x0:
 			body = append(body, frame.Data...)
 		case 0x1:
 			if _, err := decoder.Write(frame.Data); err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		default:
 			// ignore unknown frame types for now
 		}
 	}
 
-	return &types.HttpResponse{
-		Headers: headers,
-		Body:    body,
-	}, nil
+	return headers, body, nil
 }
 
 type http3Frame struct {
@@ -142,7 +204,19 @@ type http3Frame struct {
 	Data []byte
 }
 
-func readFrame(b *bufio.Reader) (*http3Frame, error) {
+// byteReader is the minimal interface readFrame and readVarInt need; both
+// *bufio.Reader (used for the request/response stream) and the bufio
+// wrapper around an accepted uni stream (see readerFor) satisfy it.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func readerFor(r io.Reader) byteReader {
+	return bufio.NewReader(r)
+}
+
+func readFrame(b byteReader) (*http3Frame, error) {
 	t, err := readVarInt(b)
 	if err != nil {
 		return nil, err
@@ -162,22 +236,40 @@ func readFrame(b *bufio.Reader) (*http3Frame, error) {
 	}, nil
 }
 
-func prepareHTTP3Request(request *types.HttpRequest) [][]byte {
-	frames := [][]byte{encodeHeaders(request.Headers)}
-	frames = append(frames, encodeBody(request.Body)...)
+func prepareHTTP3Request(request *types.HttpRequest, dynamicEntryCount int) [][]byte {
+	fields := make([]QpackHeaderField, len(request.Headers))
+	for i, h := range request.Headers {
+		fields[i] = qpackFieldFromHeader(h.Name, h.Value)
+	}
+	return prepareHTTP3RequestFields(fields, request.Body, dynamicEntryCount)
+}
+
+// prepareHTTP3RequestFields builds the HEADERS (+ DATA, if body is
+// non-empty) frames for fields/body, encoding each field with its own
+// QPACK representation (see QpackHeaderField). dynamicEntryCount pre-
+// populated entries are accounted for in the field section prefix (see
+// appendFieldSectionPrefix) regardless of whether any field in fields
+// actually references the dynamic table.
+func prepareHTTP3RequestFields(fields []QpackHeaderField, body []byte, dynamicEntryCount int) [][]byte {
+	frames := [][]byte{encodeHeaders(fields, dynamicEntryCount)}
+	frames = append(frames, encodeBody(body)...)
 	return frames
 }
 
-func encodeHeaders(headers []types.Header) []byte {
-	qpackBuf := bytes.NewBuffer(nil)
-	e := qpack.NewEncoder(qpackBuf)
-	for _, h := range headers {
-		_ = e.WriteField(qpack.HeaderField{Name: h.Name, Value: h.Value})
+// encodeHeaders QPACK-encodes fields, each with its own wire representation
+// (see QpackHeaderField), accounting for dynamicEntryCount pre-populated
+// entries in the field section prefix so the peer's required-insert-count
+// bookkeeping stays correct even when --qpack-insert is combined with a
+// request that never references the dynamic table.
+func encodeHeaders(fields []QpackHeaderField, dynamicEntryCount int) []byte {
+	qpackBuf := appendFieldSectionPrefix(nil, dynamicEntryCount)
+	for i := range fields {
+		qpackBuf = encodeQpackField(qpackBuf, &fields[i], dynamicEntryCount)
 	}
 	headersFrame := bytes.NewBuffer(nil)
 	writeVarInt(headersFrame, 0x1)
-	writeVarInt(headersFrame, uint64(qpackBuf.Len()))
-	headersFrame.Write(qpackBuf.Bytes())
+	writeVarInt(headersFrame, uint64(len(qpackBuf)))
+	headersFrame.Write(qpackBuf)
 	return headersFrame.Bytes()
 }
 
@@ -192,19 +284,6 @@ func encodeBody(body []byte) (frames [][]byte) {
 	return [][]byte{buf.Bytes()}
 }
 
-func setupSession(session quic.Connection) error {
-	stream, err := session.OpenUniStream()
-	if err != nil {
-		return err
-	}
-	buf := &bytes.Buffer{}
-	buf.Write([]byte{0x0, 0x4, 0x0}) // TODO: this is shit
-	if _, err := stream.Write(buf.Bytes()); err != nil {
-		return err
-	}
-	return nil
-}
-
 const (
 	maxVarInt1 = 63
 	maxVarInt2 = 16383
@@ -260,6 +339,12 @@ func readVarInt(b io.ByteReader) (uint64, error) {
 	return uint64(b8) + uint64(b7)<<8 + uint64(b6)<<16 + uint64(b5)<<24 + uint64(b4)<<32 + uint64(b3)<<40 + uint64(b2)<<48 + uint64(b1)<<56, nil
 }
 
+// writeVarInt encodes i as a QUIC variable-length integer (RFC 9000 §16).
+// It panics if i doesn't fit into 62 bits: callers that encode
+// user-supplied values (SETTINGS IDs/values, stream/push IDs from
+// --h3-setting or a --frames script) must validate them with
+// validateVarInt first, since those can legitimately be out of range and
+// must fail with an error, not a panic.
 func writeVarInt(b *bytes.Buffer, i uint64) {
 	if i <= maxVarInt1 {
 		b.WriteByte(uint8(i))
@@ -276,3 +361,12 @@ func writeVarInt(b *bytes.Buffer, i uint64) {
 		panic(fmt.Sprintf("%#x doesn't fit into 62 bits", i))
 	}
 }
+
+// validateVarInt returns an error if i doesn't fit into a QUIC
+// variable-length integer (RFC 9000 §16), i.e. doesn't fit into 62 bits.
+func validateVarInt(i uint64) error {
+	if i > maxVarInt8 {
+		return fmt.Errorf("%#x doesn't fit into 62 bits", i)
+	}
+	return nil
+}
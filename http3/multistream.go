@@ -0,0 +1,73 @@
+package http3
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// MultiStreamScript is the shape of a `multi-stream` script file for h3:
+// an ordered list of requests to send over a single Connection, each
+// optionally delayed relative to the start of the run (the delay is waited
+// out before the Connection's write lock is acquired). Unlike http2's
+// MultiStreamScript, there is no raw-frame or reset action: QUIC assigns
+// request stream IDs itself (see StreamSpec), so there is no stream ID to
+// collide or reuse.
+type MultiStreamScript struct {
+	Requests []MultiStreamRequest `json:"requests"`
+}
+
+// MultiStreamRequest is a single request.
+type MultiStreamRequest struct {
+	Delay   time.Duration      `json:"delay,omitempty"`
+	Headers []QpackHeaderField `json:"headers,omitempty"`
+	Body    RawBytes           `json:"body,omitempty"`
+}
+
+// LoadMultiStreamScript reads and parses a multi-stream script file.
+func LoadMultiStreamScript(path string) (*MultiStreamScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script MultiStreamScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("parsing multi-stream script: %w", err)
+	}
+	return &script, nil
+}
+
+// RunMultiStreamScript launches script's requests against conn
+// concurrently, one goroutine per request, so their Delay values control
+// the relative order each request stream is opened in instead of script
+// order (see StreamSpec.Delay). It collects each response. If any request
+// errors, the error for the lowest-indexed failing request is returned
+// alongside whatever responses were collected.
+func RunMultiStreamScript(conn *Connection, script *MultiStreamScript) ([]*Response, error) {
+	responses := make([]*Response, len(script.Requests))
+	errs := make([]error, len(script.Requests))
+
+	var wg sync.WaitGroup
+	for i, r := range script.Requests {
+		wg.Add(1)
+		go func(i int, r MultiStreamRequest) {
+			defer wg.Done()
+			resp, err := conn.SendRequest(StreamSpec{Delay: r.Delay}, r.Headers, r.Body)
+			if err != nil {
+				errs[i] = fmt.Errorf("request %d: %w", i, err)
+				return
+			}
+			responses[i] = resp
+		}(i, r)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return responses, err
+		}
+	}
+	return responses, nil
+}
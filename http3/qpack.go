@@ -0,0 +1,175 @@
+package http3
+
+import (
+	"golang.org/x/net/http2/hpack"
+)
+
+// QpackHeaderField is a single header with explicit control over its QPACK
+// wire representation (RFC 9204 §4.5), for use in frame scripts and the
+// implicit request path. The zero value encodes a literal field line with
+// literal name, matching the historical encodeHeaders behavior (modulo
+// using our own encoder instead of the marten-seemann/qpack library, so
+// that representation can be forced rather than chosen by the library).
+type QpackHeaderField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// Representation selects the wire representation: "" (default, literal
+	// field line with literal name), "name_reference" (literal field line
+	// with name reference) or "indexed" (indexed field line).
+	Representation string `json:"representation,omitempty"`
+
+	// NameIndex is the static/dynamic table index used for "name_reference".
+	// For the dynamic table, 0 means the first --qpack-insert entry (see
+	// dynamicIndexToWire), not the wire's most-recently-inserted-first
+	// relative indexing.
+	NameIndex uint64 `json:"name_index,omitempty"`
+
+	// Index is the static/dynamic table index used for "indexed", with the
+	// same --qpack-insert-order semantics as NameIndex.
+	Index uint64 `json:"index,omitempty"`
+
+	// Static selects the static table for NameIndex/Index; false means the
+	// (peer's) dynamic table.
+	Static bool `json:"static,omitempty"`
+
+	HuffmanName  *bool `json:"huffman_name,omitempty"`
+	HuffmanValue *bool `json:"huffman_value,omitempty"`
+}
+
+func qpackFieldFromHeader(name, value string) QpackHeaderField {
+	return QpackHeaderField{Name: name, Value: value}
+}
+
+// encodeQpackField appends the QPACK representation of h to dst.
+// dynamicEntryCount is the number of entries pre-populated via
+// --qpack-insert (see appendFieldSectionPrefix): a dynamic-table
+// Index/NameIndex is relative to it, so that Index/NameIndex 0 always
+// means the first --qpack-insert entry regardless of how many were
+// supplied (see dynamicIndexToWire).
+func encodeQpackField(dst []byte, h *QpackHeaderField, dynamicEntryCount int) []byte {
+	switch h.Representation {
+	case "indexed":
+		marker := byte(0x80)
+		index := h.Index
+		if h.Static {
+			marker |= 0x40
+		} else {
+			index = dynamicIndexToWire(index, dynamicEntryCount)
+		}
+		return qpackAppendPrefixedInt(dst, 6, marker, index)
+
+	case "name_reference":
+		marker := byte(0x40)
+		nameIndex := h.NameIndex
+		if h.Static {
+			marker |= 0x10
+		} else {
+			nameIndex = dynamicIndexToWire(nameIndex, dynamicEntryCount)
+		}
+		dst = qpackAppendPrefixedInt(dst, 4, marker, nameIndex)
+		return appendQpackString(dst, h.Value, boolVal(h.HuffmanValue))
+
+	default: // literal field line with literal name
+		huffmanName := boolVal(h.HuffmanName)
+		name := h.Name
+		if huffmanName {
+			name = string(hpack.AppendHuffmanString(nil, h.Name))
+		}
+		marker := byte(0x20)
+		if huffmanName {
+			marker |= 0x08
+		}
+		dst = qpackAppendPrefixedInt(dst, 3, marker, uint64(len(name)))
+		dst = append(dst, name...)
+		return appendQpackString(dst, h.Value, boolVal(h.HuffmanValue))
+	}
+}
+
+// dynamicIndexToWire converts a QpackHeaderField.Index/NameIndex for a
+// dynamic-table reference (0 = the first --qpack-insert entry, in
+// insertion order) to the wire's relative index (RFC 9204 §3.2.5: 0 = the
+// most recently inserted entry, counting backwards), given that Base ==
+// dynamicEntryCount (see appendFieldSectionPrefix).
+func dynamicIndexToWire(index uint64, dynamicEntryCount int) uint64 {
+	return uint64(dynamicEntryCount) - index - 1
+}
+
+func appendQpackString(dst []byte, s string, huffman bool) []byte {
+	if huffman {
+		encoded := hpack.AppendHuffmanString(nil, s)
+		dst = qpackAppendPrefixedInt(dst, 7, 0x80, uint64(len(encoded)))
+		return append(dst, encoded...)
+	}
+	dst = qpackAppendPrefixedInt(dst, 7, 0x00, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func boolVal(b *bool) bool {
+	return b != nil && *b
+}
+
+// qpackAppendPrefixedInt appends an RFC 9204 §4.1.1 prefixed integer, the
+// same scheme as HPACK's (RFC 7541 §5.1): marker already has its
+// representation bits set, and the low prefixBits bits of the first byte
+// carry val.
+func qpackAppendPrefixedInt(dst []byte, prefixBits int, marker byte, val uint64) []byte {
+	max := uint64(1<<uint(prefixBits)) - 1
+	if val < max {
+		return append(dst, marker|byte(val))
+	}
+	dst = append(dst, marker|byte(max))
+	val -= max
+	for val >= 128 {
+		dst = append(dst, byte(0x80|(val&0x7f)))
+		val >>= 7
+	}
+	return append(dst, byte(val))
+}
+
+// DynamicTableEntry is a name/value pair to pre-populate into the peer's
+// dynamic table before the request is sent, via an Insert With Literal
+// Name instruction (RFC 9204 §4.3.3) on the QPACK encoder stream.
+type DynamicTableEntry struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// buildQpackEncoderInstructions encodes one Insert With Literal Name
+// instruction per entry, for writing to the QPACK encoder unidirectional
+// stream (type 0x02).
+func buildQpackEncoderInstructions(entries []DynamicTableEntry) []byte {
+	var buf []byte
+	for _, e := range entries {
+		buf = qpackAppendPrefixedInt(buf, 5, 0x40, uint64(len(e.Name)))
+		buf = append(buf, e.Name...)
+		buf = appendQpackString(buf, e.Value, false)
+	}
+	return buf
+}
+
+// appendFieldSectionPrefix appends the two-field QPACK field section prefix
+// (RFC 9204 §4.5.1): the Encoded Required Insert Count and the Base
+// (encoded as a sign bit plus Delta Base), for a field section that
+// references the first dynamicEntryCount entries pre-populated on the
+// encoder stream (see buildQpackEncoderInstructions) and nothing else.
+//
+// This intentionally skips the modular wraparound RFC 9204 §4.5.1.1
+// defines for the Encoded Required Insert Count: that transform depends on
+// the dynamic table's capacity in entries, which this tool never tracks
+// (QPACK_MAX_TABLE_CAPACITY is only ever sent as an opaque SETTINGS value,
+// see SettingQpackMaxTableCapacity), and in practice is a no-op for the
+// small, never-evicted tables --qpack-insert builds. Base is set equal to
+// Required Insert Count; dynamicIndexToWire converts from that to the
+// Index/NameIndex a QpackHeaderField uses, so 0 means the first
+// --qpack-insert entry rather than the wire's most-recently-inserted-first
+// relative indexing (RFC 9204 §3.2.5).
+func appendFieldSectionPrefix(dst []byte, dynamicEntryCount int) []byte {
+	reqInsertCount := uint64(dynamicEntryCount)
+	encInsertCount := uint64(0)
+	if reqInsertCount > 0 {
+		encInsertCount = reqInsertCount + 1
+	}
+	dst = qpackAppendPrefixedInt(dst, 8, 0x00, encInsertCount)
+	return qpackAppendPrefixedInt(dst, 7, 0x00, 0) // sign bit 0, Delta Base 0: Base == Required Insert Count
+}
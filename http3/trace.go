@@ -0,0 +1,85 @@
+package http3
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Tracer records frame-level events observed on an HTTP/3 connection as
+// JSON lines, for `--trace`. Each line is tagged with TimeNs, nanoseconds
+// since the Tracer was created, so a recorded event can be correlated with
+// the side effects it caused. Unlike http2's Tracer, an HTTP/3 connection
+// has several goroutines that can observe frames concurrently (one per
+// peer unidirectional stream, plus the request stream), so writes are
+// serialized with mu.
+type Tracer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+}
+
+// NewTracer returns a Tracer that writes JSON lines to w, timestamped
+// relative to now.
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{w: w, start: time.Now()}
+}
+
+// traceEvent is the JSON shape of one recorded event. Only the fields
+// relevant to Type are populated.
+type traceEvent struct {
+	TimeNs     int64             `json:"time_ns"`
+	Type       string            `json:"type"`
+	StreamType uint64            `json:"stream_type,omitempty"`
+	FrameType  uint64            `json:"frame_type,omitempty"`
+	Length     uint64            `json:"length,omitempty"`
+	Payload    string            `json:"payload,omitempty"`
+	Settings   map[uint64]uint64 `json:"settings,omitempty"`
+}
+
+func (t *Tracer) emit(ev traceEvent) {
+	if t == nil {
+		return
+	}
+	ev.TimeNs = time.Since(t.start).Nanoseconds()
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = t.w.Write(append(data, '\n'))
+}
+
+// UniStream records a peer unidirectional stream's type (RFC 9114 §6.2),
+// decoded before any type-specific handling of the stream.
+func (t *Tracer) UniStream(streamType uint64) {
+	if t == nil {
+		return
+	}
+	t.emit(traceEvent{Type: "uni_stream", StreamType: streamType})
+}
+
+// ControlSettings records one SETTINGS frame read from the peer's control
+// stream.
+func (t *Tracer) ControlSettings(settings map[uint64]uint64) {
+	if t == nil {
+		return
+	}
+	t.emit(traceEvent{Type: "control_settings", Settings: settings})
+}
+
+// RequestFrame records one frame read from the request/response stream.
+func (t *Tracer) RequestFrame(f *http3Frame) {
+	if t == nil {
+		return
+	}
+	t.emit(traceEvent{
+		Type:      "request_frame",
+		FrameType: uint64(f.Type),
+		Length:    f.Len,
+		Payload:   hex.EncodeToString(f.Data),
+	})
+}